@@ -0,0 +1,170 @@
+package scd
+
+// batchOpKind identifies which mutation a batchOp reproduces when the batch is written.
+type batchOpKind int
+
+const (
+	batchPut batchOpKind = iota
+	batchUpdateBy
+	batchDeleteBy
+)
+
+type batchOp struct {
+	kind batchOpKind
+	col  int
+	id   string
+	row  []string
+}
+
+// Batch accumulates Insert/Update/Delete operations in memory so they can be applied to a Table
+// as a single all-or-nothing unit via Table.Write.
+type Batch struct {
+	ops []batchOp
+}
+
+// NewBatch creates an empty Batch for the table.
+func (t *Table) NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Put appends an insert operation to the batch.
+func (b *Batch) Put(record RecordType) error {
+	row, err := record.ToRow()
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{kind: batchPut, row: row})
+	return nil
+}
+
+// UpdateBy appends an operation that replaces the row whose col column equals id, the same way
+// Table.Update would.
+func (b *Batch) UpdateBy(col int, id string, record RecordType) error {
+	row, err := record.ToRow()
+	if err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{kind: batchUpdateBy, col: col, id: id, row: row})
+	return nil
+}
+
+// DeleteBy appends an operation that removes the row whose col column equals id, the same way
+// Table.Delete would.
+func (b *Batch) DeleteBy(col int, id string) {
+	b.ops = append(b.ops, batchOp{kind: batchDeleteBy, col: col, id: id})
+}
+
+// Len returns the number of operations accumulated in the batch.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Reset discards every operation accumulated so far so the batch can be reused.
+func (b *Batch) Reset() {
+	b.ops = nil
+}
+
+// findIn returns the index of the first row in rows whose col column equals id.
+func findIn(rows [][]string, col int, id string) (int, error) {
+	for i := 0; i < len(rows); i++ {
+		if col >= len(rows[i]) {
+			return 0, FindOutOfIndex
+		}
+		if rows[i][col] == id {
+			return i, nil
+		}
+	}
+	return 0, ValueNotFound
+}
+
+// findLocked returns the index of the first row whose col column equals id. t.mutex must already
+// be held by the caller.
+func (t *Table) findLocked(col int, id string) (int, error) {
+	return findIn(t.rows, col, id)
+}
+
+// simulateBatch replays b's operations against a scratch copy of rows, the same way Write's
+// commit loop will for real, so that an op whose target only exists (or only stopped existing)
+// because of an earlier op in the same batch is caught before anything in the table is touched.
+// It returns the first error any op would hit, or nil if the whole batch would commit cleanly.
+func simulateBatch(b *Batch, rows [][]string) error {
+	scratch := make([][]string, len(rows))
+	copy(scratch, rows)
+	for _, op := range b.ops {
+		switch op.kind {
+		case batchPut:
+			scratch = append(scratch, op.row)
+		case batchUpdateBy:
+			idx, err := findIn(scratch, op.col, op.id)
+			if err != nil {
+				return err
+			}
+			scratch[idx] = op.row
+		case batchDeleteBy:
+			idx, err := findIn(scratch, op.col, op.id)
+			if err != nil {
+				return err
+			}
+			last := len(scratch) - 1
+			scratch[idx] = scratch[last]
+			scratch = scratch[:last]
+		}
+	}
+	return nil
+}
+
+// Write applies every operation in b to the table as a single all-or-nothing unit: it first
+// replays the whole batch against a scratch copy of the rows via simulateBatch, returning
+// FindOutOfIndex or ValueNotFound without mutating anything if any op fails to resolve there —
+// including one whose target only existed, or only stopped existing, because of an earlier op in
+// the same batch — then commits every operation for real under one lock and raises a single
+// change notification, modeled on leveldb's Batch/BatchReplay.
+func (t *Table) Write(b *Batch) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := simulateBatch(b, t.rows); err != nil {
+		return err
+	}
+
+	t.cloneIfShared()
+	for _, op := range b.ops {
+		switch op.kind {
+		case batchPut:
+			if err := t.appendWAL(walInsert, encodeRow(op.row)); err != nil {
+				return err
+			}
+			t.rows = append(t.rows, op.row)
+			t.indexInsert(len(t.rows)-1, op.row)
+		case batchUpdateBy:
+			idx, err := t.findLocked(op.col, op.id)
+			if err != nil {
+				return err
+			}
+			if err := t.appendWAL(walUpdate, encodeColIDRow(op.col, op.id, op.row)); err != nil {
+				return err
+			}
+			oldRow := t.rows[idx]
+			t.rows[idx] = op.row
+			t.indexRemove(idx, oldRow)
+			t.indexInsert(idx, op.row)
+		case batchDeleteBy:
+			idx, err := t.findLocked(op.col, op.id)
+			if err != nil {
+				return err
+			}
+			if err := t.appendWAL(walDelete, encodeColID(op.col, op.id)); err != nil {
+				return err
+			}
+			t.swapDelete(idx)
+		}
+	}
+
+	if len(b.ops) > 0 {
+		select {
+		case t.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
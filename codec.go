@@ -0,0 +1,101 @@
+package scd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/golang/snappy"
+)
+
+// Codec compresses and decompresses a table's on-disk snapshot. The write-ahead log is always
+// stored uncompressed, since its records must stay individually appendable and are already
+// compact.
+//
+// CodecPlain and CodecGzip are backed by the standard library, CodecSnappy by the snappy package.
+// A Codec is only used for writing: opening a table reads through detectCodec, which recognises
+// gzip and snappy snapshots by their magic bytes and reads anything else as plain CSV, so
+// NewReader is not called by Table.
+type Codec interface {
+	// Name identifies the codec; it has no effect on file contents, it is only for logging/tests.
+	Name() string
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// CodecPlain stores the table snapshot as-is, exactly as earlier versions of this package always
+// did. It is the default.
+var CodecPlain Codec = plainCodec{}
+
+// CodecGzip stores the table snapshot gzip-compressed.
+var CodecGzip Codec = gzipCodec{}
+
+// CodecSnappy stores the table snapshot snappy-compressed, trading CodecGzip's better ratio for
+// faster compression and decompression.
+var CodecSnappy Codec = snappyCodec{}
+
+type plainCodec struct{}
+
+func (plainCodec) Name() string { return "plain" }
+
+func (plainCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func (plainCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(snappy.NewReader(r)), nil
+}
+
+func (snappyCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewWriter(w), nil
+}
+
+var gzipMagic = [2]byte{0x1f, 0x8b}
+
+// snappyMagic is the stream identifier chunk every snappy.Writer emits first; see the "sNaPpY"
+// framing format documented by the snappy package.
+var snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+// detectCodec peeks at r's first bytes to tell a gzip- or snappy-compressed snapshot from a plain
+// one, regardless of which Codec the table is configured with: a table must always be able to
+// read back a snapshot written under a previous Codec option. The returned reader still yields
+// the full stream, since peeking through a bufio.Reader consumes nothing irrecoverably.
+func detectCodec(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(snappyMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.HasPrefix(magic, snappyMagic) {
+		return io.NopCloser(snappy.NewReader(br)), nil
+	}
+	if len(magic) >= 2 && magic[0] == gzipMagic[0] && magic[1] == gzipMagic[1] {
+		return gzip.NewReader(br)
+	}
+	return io.NopCloser(br), nil
+}
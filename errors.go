@@ -1,8 +1,24 @@
 package scd
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	FindOutOfIndex = errors.New("the specified column number is out of range")
 	ValueNotFound  = errors.New("cannot find the matched value")
 )
+
+// ErrCorrupted is returned by OpenTable when the write-ahead log cannot be replayed because a
+// record fails its checksum. Offset is the byte offset of the failing record within the WAL
+// file; the caller can pass it to OpenTableTruncateWAL to discard everything from that point on
+// and recover the table up to the last good record.
+type ErrCorrupted struct {
+	Reason string
+	Offset int64
+}
+
+func (e *ErrCorrupted) Error() string {
+	return fmt.Sprintf("scd: WAL corrupted at offset %d: %s", e.Offset, e.Reason)
+}
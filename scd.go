@@ -1,9 +1,12 @@
 package scd
 
 import (
+	"encoding/binary"
 	"encoding/csv"
+	"hash/crc32"
+	"io"
 	"log"
-	"os"
+	"sort"
 	"sync"
 )
 
@@ -14,64 +17,390 @@ type RecordType interface {
 	FromRow(row []string) (RecordType, error)
 }
 
+const (
+	tmpSuffix  = ".tmp"
+	walSuffix  = ".wal"
+	metaSuffix = ".meta"
+)
+
 type Table struct {
 	recordType RecordType
 	rows       [][]string
-	file       *os.File
+	path       string
+	walFile    File
+	storage    Storage
+	codec      Codec
+	syncMode   SyncMode
 	changed    chan struct{}
 	close      chan struct{}
 	mutex      sync.Mutex
+
+	// sharedWithSnapshot is set whenever a live Snapshot may still be holding a reference to
+	// t.rows' current backing array; the next mutation must clone before writing so the
+	// snapshot's view stays consistent. See cloneIfShared.
+	sharedWithSnapshot bool
+
+	// header, colIndex and colNames are only set when the table was opened with
+	// OpenTableWithHeader; header is nil otherwise.
+	header   []string
+	colIndex map[string]int
+	colNames map[int]string
+	// indexes maps a column name to an in-memory value -> row-indices index for that column.
+	indexes map[string]map[string][]int
+}
+
+// Options configures OpenTableWithOptions.
+type Options struct {
+	// SyncMode controls whether the WAL is fsynced after every mutating operation.
+	SyncMode SyncMode
+	// Storage backs the table's snapshot and WAL files. Nil means FileStorage, the local disk.
+	Storage Storage
+	// Codec compresses the table's snapshot file on every flush. Nil means CodecPlain, no
+	// compression. A table always autodetects the codec of the snapshot it reads, regardless of
+	// this setting, so Codec can be changed freely between opens.
+	Codec Codec
+}
+
+func storageOrDefault(s Storage) Storage {
+	if s == nil {
+		return FileStorage{}
+	}
+	return s
+}
+
+func codecOrDefault(c Codec) Codec {
+	if c == nil {
+		return CodecPlain
+	}
+	return c
 }
 
 // OpenTable opens a table (csv file), if not exists then create
 func OpenTable(path string, recordType RecordType) (*Table, error) {
-	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	return OpenTableWithOptions(path, recordType, Options{})
+}
+
+// OpenTableWithOptions opens a table like OpenTable, additionally applying opts. If the table was
+// left behind by a crash mid-flush, it is recovered first: a leftover ".tmp" snapshot is promoted
+// over path only if its sibling ".meta" checksum confirms it was fully written and fsync'd before
+// the crash; otherwise it is a partial snapshot from a flush that never finished, so it is
+// discarded and the untouched snapshot at path is kept instead. Any records still in the ".wal"
+// file are then replayed on top of the resulting snapshot. A record that fails its checksum is
+// reported as *ErrCorrupted instead of being replayed.
+func OpenTableWithOptions(path string, recordType RecordType, opts Options) (*Table, error) {
+	return openTable(path, recordType, opts, false)
+}
+
+// openTable backs OpenTable, OpenTableWithOptions and OpenTableWithHeader. When hasHeader is
+// true, the first row read from disk (or an empty row, if the file is new) is kept as the
+// table's header instead of being treated as data.
+func openTable(path string, recordType RecordType, opts Options, hasHeader bool) (*Table, error) {
+	storage := storageOrDefault(opts.Storage)
+	codec := codecOrDefault(opts.Codec)
+
+	tmpPath := path + tmpSuffix
+	metaPath := tmpPath + metaSuffix
+	walPath := path + walSuffix
+	recovering := false
+
+	if exists, err := storage.Exists(tmpPath); err != nil {
+		return nil, err
+	} else if exists {
+		complete, err := verifySnapshotMeta(storage, tmpPath, metaPath)
+		if err != nil {
+			return nil, err
+		}
+		if complete {
+			if err := storage.Rename(tmpPath, path); err != nil {
+				return nil, err
+			}
+			recovering = true
+		} else if err := storage.Remove(tmpPath); err != nil {
+			return nil, err
+		}
+		if err := storage.Remove(metaPath); err != nil {
+			return nil, err
+		}
+	} else if err := storage.Remove(metaPath); err != nil {
+		return nil, err
+	}
+
+	file, err := storage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := detectCodec(file)
 	if err != nil {
+		_ = file.Close()
 		return nil, err
 	}
-	reader := csv.NewReader(file)
+	reader := csv.NewReader(decoded)
 	rows, err := reader.ReadAll()
+	if closeErr := decoded.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		if err := file.Close(); err != nil {
 			log.Fatalln(err)
 		}
 		return nil, err
 	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if hasHeader {
+		if len(rows) > 0 {
+			header = rows[0]
+			rows = rows[1:]
+		} else {
+			header = []string{}
+		}
+	}
+
+	walFile, err := storage.Open(walPath)
+	if err != nil {
+		return nil, err
+	}
+	size, err := fileSize(walFile)
+	if err != nil {
+		_ = walFile.Close()
+		return nil, err
+	}
+	if size > 0 {
+		rows, err = replayWAL(walFile, rows)
+		if err != nil {
+			_ = walFile.Close()
+			return nil, err
+		}
+		recovering = true
+	}
+	if _, err := walFile.Seek(0, io.SeekEnd); err != nil {
+		_ = walFile.Close()
+		return nil, err
+	}
+
 	newCsv := &Table{
 		recordType: recordType,
 		rows:       rows,
-		file:       file,
+		path:       path,
+		walFile:    walFile,
+		storage:    storage,
+		codec:      codec,
+		syncMode:   opts.SyncMode,
 		changed:    make(chan struct{}, 1),
 		close:      make(chan struct{}),
 		mutex:      sync.Mutex{},
 	}
+
+	if hasHeader {
+		newCsv.header = header
+		newCsv.colIndex = make(map[string]int, len(header))
+		newCsv.colNames = make(map[int]string, len(header))
+		for i, name := range header {
+			newCsv.colIndex[name] = i
+			newCsv.colNames[i] = name
+		}
+	}
+
+	if recovering {
+		if err := newCsv.flushSnapshot(); err != nil {
+			_ = walFile.Close()
+			return nil, err
+		}
+	}
+
 	return newCsv, nil
 }
 
+// OpenTableTruncateWAL truncates the table's WAL file at offset (typically the Offset reported
+// by an *ErrCorrupted from OpenTable), discarding that record and everything after it, then opens
+// the table normally.
+func OpenTableTruncateWAL(path string, recordType RecordType, offset int64) (*Table, error) {
+	return OpenTableTruncateWALWithOptions(path, recordType, offset, Options{})
+}
+
+// OpenTableTruncateWALWithOptions is OpenTableTruncateWAL, additionally applying opts; opts.Storage
+// must be the same Storage the corrupted table was opened with.
+func OpenTableTruncateWALWithOptions(path string, recordType RecordType, offset int64, opts Options) (*Table, error) {
+	storage := storageOrDefault(opts.Storage)
+	walPath := path + walSuffix
+	exists, err := storage.Exists(walPath)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return OpenTableWithOptions(path, recordType, opts)
+	}
+	walFile, err := storage.Open(walPath)
+	if err != nil {
+		return nil, err
+	}
+	err = walFile.Truncate(offset)
+	if closeErr := walFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return OpenTableWithOptions(path, recordType, opts)
+}
+
 // ListenChange listen to recordType change signal, whenever a change happens and the recordType is idle,
 // writes the records to the csv file. This function will return an error after the recordType is closed
 func (t *Table) ListenChange() error {
 	for {
 		select {
 		case <-t.changed:
-			writer := csv.NewWriter(t.file)
-			t.mutex.Lock()
-			if err := t.file.Truncate(0); err != nil {
-				panic(err)
-			}
-			if _, err := t.file.Seek(0, 0); err != nil {
-				panic(err)
-			}
-			if err := writer.WriteAll(t.rows); err != nil {
+			if err := t.flushSnapshot(); err != nil {
 				panic(err)
 			}
-			t.mutex.Unlock()
 		case <-t.close:
-			return t.file.Close()
+			return t.walFile.Close()
 		}
 	}
 }
 
+// flushSnapshot durably writes t.rows as the table's new on-disk state: it writes the full CSV to
+// a sibling ".tmp" file, fsyncs it, records the written bytes' checksum in a sibling ".meta" file
+// so a later OpenTable can tell a complete ".tmp" from one caught mid-write by a crash, atomically
+// renames the ".tmp" over path, then truncates and fsyncs the WAL, since every record in it is now
+// reflected in the snapshot.
+func (t *Table) flushSnapshot() error {
+	tmpPath := t.path + tmpSuffix
+	metaPath := tmpPath + metaSuffix
+	tmpFile, err := t.storage.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	hasher := crc32.NewIEEE()
+	codecWriter, err := t.codec.NewWriter(io.MultiWriter(tmpFile, hasher))
+	if err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	writer := csv.NewWriter(codecWriter)
+	if t.header != nil {
+		if err := writer.Write(t.header); err != nil {
+			_ = tmpFile.Close()
+			return err
+		}
+	}
+	if err := writer.WriteAll(t.rows); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := codecWriter.Close(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := writeSnapshotMeta(t.storage, metaPath, hasher.Sum32()); err != nil {
+		return err
+	}
+	if err := t.storage.Rename(tmpPath, t.path); err != nil {
+		return err
+	}
+	if err := t.storage.Remove(metaPath); err != nil {
+		return err
+	}
+	if err := t.walFile.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := t.walFile.Seek(0, 0); err != nil {
+		return err
+	}
+	if err := t.walFile.Sync(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// writeSnapshotMeta records checksum (the CRC32 of a just-written ".tmp" snapshot's bytes) at
+// metaPath so a later OpenTable can verify the ".tmp" is complete before promoting it.
+func writeSnapshotMeta(storage Storage, metaPath string, checksum uint32) error {
+	metaFile, err := storage.Create(metaPath)
+	if err != nil {
+		return err
+	}
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], checksum)
+	if _, err := metaFile.Write(buf[:]); err != nil {
+		_ = metaFile.Close()
+		return err
+	}
+	if err := metaFile.Sync(); err != nil {
+		_ = metaFile.Close()
+		return err
+	}
+	return metaFile.Close()
+}
+
+// verifySnapshotMeta reports whether the ".tmp" snapshot at tmpPath is complete: metaPath must
+// exist and hold the CRC32 that flushSnapshot recorded for it, and that checksum must match
+// tmpPath's actual bytes. A crash between creating ".tmp" (which truncates it into existence) and
+// writeSnapshotMeta leaves no ".meta", so the tmp is correctly reported incomplete.
+func verifySnapshotMeta(storage Storage, tmpPath, metaPath string) (bool, error) {
+	metaExists, err := storage.Exists(metaPath)
+	if err != nil {
+		return false, err
+	}
+	if !metaExists {
+		return false, nil
+	}
+	metaFile, err := storage.Open(metaPath)
+	if err != nil {
+		return false, err
+	}
+	defer metaFile.Close()
+	size, err := fileSize(metaFile)
+	if err != nil {
+		return false, err
+	}
+	if size != 4 {
+		return false, nil
+	}
+	var buf [4]byte
+	if _, err := io.ReadFull(metaFile, buf[:]); err != nil {
+		return false, err
+	}
+	want := binary.BigEndian.Uint32(buf[:])
+
+	tmpFile, err := storage.Open(tmpPath)
+	if err != nil {
+		return false, err
+	}
+	defer tmpFile.Close()
+	hasher := crc32.NewIEEE()
+	if _, err := io.Copy(hasher, tmpFile); err != nil {
+		return false, err
+	}
+	return hasher.Sum32() == want, nil
+}
+
+// cloneIfShared makes a private copy of t.rows' backing array if a live Snapshot might still be
+// reading the old one, so an in-place mutation never changes what an already-taken Snapshot
+// observes. t.mutex must already be held.
+func (t *Table) cloneIfShared() {
+	if !t.sharedWithSnapshot {
+		return
+	}
+	rows := make([][]string, len(t.rows))
+	copy(rows, t.rows)
+	t.rows = rows
+	t.sharedWithSnapshot = false
+}
+
 // Close the recordType (csv file)
 func (t *Table) Close() {
 	close(t.close)
@@ -98,6 +427,19 @@ func (t *Table) All() ([]RecordType, error) {
 func (t *Table) Select(col int, id string) (RecordType, error) {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if bucket, ok := t.indexedBucket(col); ok {
+		ids := bucket[id]
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		first := ids[0]
+		for _, i := range ids[1:] {
+			if i < first {
+				first = i
+			}
+		}
+		return t.recordType.FromRow(t.rows[first])
+	}
 	for i := 0; i < len(t.rows); i++ {
 		if col >= len(t.rows[i]) {
 			return nil, FindOutOfIndex
@@ -118,6 +460,16 @@ func (t *Table) SelectAll(col int, by string) ([]RecordType, error) {
 	records := make([]RecordType, 0)
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if bucket, ok := t.indexedBucket(col); ok {
+		for _, i := range bucket[by] {
+			record, err := t.recordType.FromRow(t.rows[i])
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
 	for i := 0; i < len(t.rows); i++ {
 		if col >= len(t.rows[i]) {
 			return nil, FindOutOfIndex
@@ -140,7 +492,13 @@ func (t *Table) Insert(record RecordType) error {
 		return err
 	}
 	t.mutex.Lock()
+	if err := t.appendWAL(walInsert, encodeRow(row)); err != nil {
+		t.mutex.Unlock()
+		return err
+	}
+	t.cloneIfShared()
 	t.rows = append(t.rows, row)
+	t.indexInsert(len(t.rows)-1, row)
 	t.mutex.Unlock()
 	// use select to avoid channel block
 	select {
@@ -161,7 +519,16 @@ func (t *Table) InsertAll(records []RecordType) error {
 		rows = append(rows, row)
 	}
 	t.mutex.Lock()
+	if err := t.appendWAL(walInsertAll, encodeRows(rows)); err != nil {
+		t.mutex.Unlock()
+		return err
+	}
+	t.cloneIfShared()
+	base := len(t.rows)
 	t.rows = append(t.rows, rows...)
+	for i, row := range rows {
+		t.indexInsert(base+i, row)
+	}
 	t.mutex.Unlock()
 	// use select to avoid channel block
 	select {
@@ -184,7 +551,14 @@ func (t *Table) Update(col int, id string, record RecordType) error {
 			return FindOutOfIndex
 		}
 		if t.rows[i][col] == id {
+			if err := t.appendWAL(walUpdate, encodeColIDRow(col, id, row)); err != nil {
+				return err
+			}
+			t.cloneIfShared()
+			oldRow := t.rows[i]
 			t.rows[i] = row
+			t.indexRemove(i, oldRow)
+			t.indexInsert(i, row)
 			// use select to avoid channel block
 			select {
 			case t.changed <- struct{}{}:
@@ -202,40 +576,92 @@ func (t *Table) UpdateAll(col int, by string, record RecordType) error {
 	if err != nil {
 		return err
 	}
-	updated := false
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	updated := false
 	for i := 0; i < len(t.rows); i++ {
 		if col >= len(t.rows[i]) {
 			return FindOutOfIndex
 		}
 		if t.rows[i][col] == by {
-			t.rows[i] = row
 			updated = true
 		}
 	}
-	if updated {
-		// use select to avoid channel block
-		select {
-		case t.changed <- struct{}{}:
-		default:
+	if !updated {
+		return ValueNotFound
+	}
+	if err := t.appendWAL(walUpdateAll, encodeColIDRow(col, by, row)); err != nil {
+		return err
+	}
+	t.cloneIfShared()
+	for i := 0; i < len(t.rows); i++ {
+		if t.rows[i][col] == by {
+			oldRow := t.rows[i]
+			t.rows[i] = row
+			t.indexRemove(i, oldRow)
+			t.indexInsert(i, row)
 		}
-		return nil
 	}
-	return ValueNotFound
+	// use select to avoid channel block
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// swapDelete removes the row at index i, swapping the last row into its place the same way
+// Delete/DeleteAll always have, and keeps any maintained indexes in sync with the move.
+// t.mutex must already be held.
+func (t *Table) swapDelete(i int) {
+	removedRow := t.rows[i]
+	last := len(t.rows) - 1
+	movedRow := t.rows[last]
+	t.rows[i] = movedRow
+	t.rows = t.rows[:last]
+	t.indexRemove(i, removedRow)
+	if i != last {
+		t.indexMove(last, i, movedRow)
+	}
 }
 
 // Delete a row based on its id, col and id work the same as Select
 func (t *Table) Delete(col int, id string) error {
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
+	if bucket, ok := t.indexedBucket(col); ok {
+		ids := bucket[id]
+		if len(ids) == 0 {
+			return ValueNotFound
+		}
+		if err := t.appendWAL(walDelete, encodeColID(col, id)); err != nil {
+			return err
+		}
+		first := ids[0]
+		for _, i := range ids[1:] {
+			if i < first {
+				first = i
+			}
+		}
+		t.cloneIfShared()
+		t.swapDelete(first)
+		// use select to avoid channel block
+		select {
+		case t.changed <- struct{}{}:
+		default:
+		}
+		return nil
+	}
 	for i := 0; i < len(t.rows); i++ {
 		if col >= len(t.rows[i]) {
 			return FindOutOfIndex
 		}
 		if t.rows[i][col] == id {
-			t.rows[i] = t.rows[len(t.rows)-1]
-			t.rows = t.rows[:len(t.rows)-1]
+			if err := t.appendWAL(walDelete, encodeColID(col, id)); err != nil {
+				return err
+			}
+			t.cloneIfShared()
+			t.swapDelete(i)
 			// use select to avoid channel block
 			select {
 			case t.changed <- struct{}{}:
@@ -249,20 +675,21 @@ func (t *Table) Delete(col int, id string) error {
 
 // DeleteAll rows that has the specified value on the specified column, col and id work the same as Select
 func (t *Table) DeleteAll(col int, by string) error {
-	deleted := false
 	t.mutex.Lock()
 	defer t.mutex.Unlock()
-	for i := len(t.rows) - 1; i >= 0; i-- {
-		if col >= len(t.rows[i]) {
-			return FindOutOfIndex
+	if bucket, ok := t.indexedBucket(col); ok {
+		ids := append([]int(nil), bucket[by]...)
+		if len(ids) == 0 {
+			return ValueNotFound
 		}
-		if t.rows[i][col] == by {
-			t.rows[i] = t.rows[len(t.rows)-1]
-			t.rows = t.rows[:len(t.rows)-1]
-			deleted = true
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+		if err := t.appendWAL(walDeleteAll, encodeColID(col, by)); err != nil {
+			return err
+		}
+		t.cloneIfShared()
+		for _, i := range ids {
+			t.swapDelete(i)
 		}
-	}
-	if deleted {
 		// use select to avoid channel block
 		select {
 		case t.changed <- struct{}{}:
@@ -270,5 +697,32 @@ func (t *Table) DeleteAll(col int, by string) error {
 		}
 		return nil
 	}
-	return ValueNotFound
+
+	deleted := false
+	for i := 0; i < len(t.rows); i++ {
+		if col >= len(t.rows[i]) {
+			return FindOutOfIndex
+		}
+		if t.rows[i][col] == by {
+			deleted = true
+		}
+	}
+	if !deleted {
+		return ValueNotFound
+	}
+	if err := t.appendWAL(walDeleteAll, encodeColID(col, by)); err != nil {
+		return err
+	}
+	t.cloneIfShared()
+	for i := len(t.rows) - 1; i >= 0; i-- {
+		if t.rows[i][col] == by {
+			t.swapDelete(i)
+		}
+	}
+	// use select to avoid channel block
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+	return nil
 }
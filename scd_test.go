@@ -1,7 +1,9 @@
 package scd
 
 import (
+	"bytes"
 	"errors"
+	"os"
 	"strconv"
 	"testing"
 )
@@ -151,3 +153,524 @@ func Test(t *testing.T) {
 
 	table.Close()
 }
+
+// TestWALRecovery simulates a crash: operations are appended to the WAL but ListenChange is
+// never run, so the CSV file is never flushed. Reopening the table must replay the WAL and
+// recover every operation.
+func TestWALRecovery(t *testing.T) {
+	path := "./test_wal.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := OpenTable(path, TestRecordType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no ListenChange goroutine: these operations only ever reach the WAL
+
+	if err := table.Insert(TestRecordType{Id: 1, Name: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(TestRecordType{Id: 2, Name: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Update(0, "2", TestRecordType{Id: 2, Name: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := OpenTable(path, TestRecordType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := recovered.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 recovered rows, got %d", len(all))
+	}
+	row2, err := recovered.Select(0, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row2.(TestRecordType).Name != "updated" {
+		t.Fatalf("expected recovered row to reflect the update, got %v", row2)
+	}
+	recovered.Close()
+}
+
+// TestWALCorrupted checks that a damaged WAL record is reported as *ErrCorrupted instead of
+// being silently dropped or replayed.
+func TestWALCorrupted(t *testing.T) {
+	path := "./test_wal_corrupt.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := OpenTable(path, TestRecordType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(TestRecordType{Id: 1, Name: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	walFile, err := os.OpenFile(path+".wal", os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte inside the record's payload (past the 8-byte length+crc header) so the
+	// length prefix stays intact and only the checksum comparison fails
+	if _, err := walFile.WriteAt([]byte{0xff}, 8); err != nil {
+		t.Fatal(err)
+	}
+	if err := walFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = OpenTable(path, TestRecordType{})
+	var corrupted *ErrCorrupted
+	if !errors.As(err, &corrupted) {
+		t.Fatalf("expected *ErrCorrupted, got %v", err)
+	}
+}
+
+// TestSnapshotCrashRecovery checks that a leftover ".tmp" file from a crash that happened before
+// the snapshot it was writing finished (and so never got a ".meta" checksum) is discarded instead
+// of being promoted over the real, older snapshot at path.
+func TestSnapshotCrashRecovery(t *testing.T) {
+	storage := NewMemStorage()
+	path := "./test_crash.csv"
+
+	table, err := OpenTableWithOptions(path, TestRecordType{}, Options{Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(TestRecordType{Id: 1, Name: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(TestRecordType{Id: 2, Name: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	// flush synchronously so the good snapshot at path reflects both rows and the WAL is
+	// truncated, the same state a long-running ListenChange goroutine would reach
+	if err := table.flushSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a third insert only ever reaches the WAL
+	if err := table.Insert(TestRecordType{Id: 3, Name: "ghi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash partway through the next flush: storage.Create truncates the ".tmp" file
+	// into existence before a single row is written to it, so a leftover empty ".tmp" with no
+	// ".meta" is exactly what that crash window leaves behind
+	if _, err := storage.Create(path + tmpSuffix); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := OpenTableWithOptions(path, TestRecordType{}, Options{Storage: storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := recovered.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected the good snapshot plus the WAL-only row to survive a partial tmp, got %v", all)
+	}
+}
+
+// TestBatch checks that a batch commits all of its operations together, and that a batch
+// containing an invalid target is rejected without mutating the table.
+func TestBatch(t *testing.T) {
+	path := "./test_batch.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := OpenTable(path, TestRecordType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := table.ListenChange(); err != nil {
+			panic(err)
+		}
+	}()
+	defer table.Close()
+
+	if err := table.Insert(TestRecordType{Id: 1, Name: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := table.NewBatch()
+	if err := batch.Put(TestRecordType{Id: 2, Name: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.UpdateBy(0, "1", TestRecordType{Id: 1, Name: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	batch.DeleteBy(0, "3") // does not exist, so the whole batch must be rejected
+	if batch.Len() != 3 {
+		t.Fatalf("expected 3 ops in the batch, got %d", batch.Len())
+	}
+
+	if err := table.Write(batch); err != ValueNotFound {
+		t.Fatalf("expected ValueNotFound, got %v", err)
+	}
+	all, err := table.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("rejected batch must not mutate the table, got %v", all)
+	}
+
+	batch.Reset()
+	if batch.Len() != 0 {
+		t.Fatalf("expected batch to be empty after Reset, got %d ops", batch.Len())
+	}
+	if err := batch.Put(TestRecordType{Id: 2, Name: "def"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.UpdateBy(0, "1", TestRecordType{Id: 1, Name: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	all, err = table.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected 2 rows after the batch commits, got %v", all)
+	}
+	row1, err := table.Select(0, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row1.(TestRecordType).Name != "updated" {
+		t.Fatalf("expected batch update to apply, got %v", row1)
+	}
+}
+
+// TestBatchEvolvingTarget checks that a batch whose second op targets a value only the first op
+// could have produced or removed fails instead of falling through to row index 0, and that the
+// whole batch is rejected atomically: here UpdateBy moves id "2" to id "99", so the
+// DeleteBy(0, "2") that follows no longer has a target by the time it commits, and the UpdateBy
+// must not be left applied either.
+func TestBatchEvolvingTarget(t *testing.T) {
+	path := "./test_batch_evolving.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := OpenTable(path, TestRecordType{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := table.ListenChange(); err != nil {
+			panic(err)
+		}
+	}()
+	defer table.Close()
+
+	if err := table.Insert(TestRecordType{Id: 1, Name: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(TestRecordType{Id: 2, Name: "def"}); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := table.NewBatch()
+	if err := batch.UpdateBy(0, "2", TestRecordType{Id: 99, Name: "moved"}); err != nil {
+		t.Fatal(err)
+	}
+	batch.DeleteBy(0, "2") // "2" no longer exists once the UpdateBy above commits
+
+	if err := table.Write(batch); err != ValueNotFound {
+		t.Fatalf("expected ValueNotFound, got %v", err)
+	}
+	row1, err := table.Select(0, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row1 == nil {
+		t.Fatal("expected the unrelated id=1 row to survive the rejected batch, got none")
+	}
+	row2, err := table.Select(0, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row2 == nil {
+		t.Fatal("expected the UpdateBy half of the rejected batch not to be applied, but id=2 is gone")
+	}
+	row99, err := table.Select(0, "99")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row99 != nil {
+		t.Fatalf("expected the UpdateBy half of the rejected batch not to be applied, but found %v", row99)
+	}
+}
+
+// TestHeaderAndIndex checks the named-column lookups and that an indexed column stays correct
+// through inserts, updates and the swap-delete used by Delete/DeleteAll.
+func TestHeaderAndIndex(t *testing.T) {
+	path := "./test_header.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	if err := os.WriteFile(path, []byte("id,name\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := OpenTableWithHeader(path, TestRecordType{}, SchemaOptions{Indexes: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := table.ListenChange(); err != nil {
+			panic(err)
+		}
+	}()
+	defer table.Close()
+
+	if err := table.InsertAll([]RecordType{
+		TestRecordType{Id: 1, Name: "abc"},
+		TestRecordType{Id: 2, Name: "def"},
+		TestRecordType{Id: 3, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	byName, err := table.SelectAllByName("name", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byName) != 2 {
+		t.Fatalf("expected 2 rows named def, got %v", byName)
+	}
+
+	if err := table.UpdateByName("name", "abc", TestRecordType{Id: 1, Name: "xyz"}); err != nil {
+		t.Fatal(err)
+	}
+	if row, err := table.SelectByName("name", "xyz"); err != nil || row == nil {
+		t.Fatalf("expected updated row to be found by its new name, got %v, %v", row, err)
+	}
+
+	// delete one of the two rows sharing the indexed value, then make sure the index still
+	// finds the surviving row even though Delete's swap-delete moved rows around
+	if err := table.DeleteByName("name", "def"); err != nil {
+		t.Fatal(err)
+	}
+	byName, err = table.SelectAllByName("name", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(byName) != 1 {
+		t.Fatalf("expected 1 remaining row named def, got %v", byName)
+	}
+
+	if _, err := table.SelectByName("missing", "x"); err != FindOutOfIndex {
+		t.Fatalf("expected FindOutOfIndex for an unknown column name, got %v", err)
+	}
+}
+
+// TestSnapshot checks that a Snapshot's view stays frozen across a later mutation (the
+// copy-on-write path), and that Where/Find stream-filter it without touching the table's lock.
+func TestSnapshot(t *testing.T) {
+	path := "./test_snapshot.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	if err := os.WriteFile(path, []byte("id,name\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := OpenTableWithHeader(path, TestRecordType{}, SchemaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := table.ListenChange(); err != nil {
+			panic(err)
+		}
+	}()
+	defer table.Close()
+
+	if err := table.InsertAll([]RecordType{
+		TestRecordType{Id: 1, Name: "abc"},
+		TestRecordType{Id: 2, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := table.Snapshot()
+
+	// mutate the table after the snapshot was taken; snap must keep seeing the old state
+	if err := table.Insert(TestRecordType{Id: 3, Name: "ghi"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete(0, "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	it := snap.Iterator()
+	count := 0
+	for it.Next() {
+		record, err := it.Record()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.(TestRecordType).Id == 3 {
+			t.Fatal("snapshot must not observe a row inserted after it was taken")
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected the snapshot to keep its original 2 rows, got %d", count)
+	}
+	it.Release()
+
+	matches, err := snap.Where(func(record RecordType) bool {
+		return record.(TestRecordType).Name == "def"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match from Where, got %v", matches)
+	}
+
+	found, err := snap.Find("name", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatalf("expected 1 match from Find, including the row later deleted from the table, got %v", found)
+	}
+
+	all, err := table.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("expected the live table to reflect the insert and delete, got %v", all)
+	}
+}
+
+func TestPluggableStorageAndCodec(t *testing.T) {
+	storage := NewMemStorage()
+	opts := Options{Storage: storage, Codec: CodecGzip}
+	path := "./test_storage.csv"
+
+	table, err := OpenTableWithOptions(path, TestRecordType{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if err := table.InsertAll([]RecordType{
+		TestRecordType{Id: 1, Name: "abc"},
+		TestRecordType{Id: 2, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// flush synchronously instead of going through the ListenChange/changed-channel plumbing
+	if err := table.flushSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot file must actually be gzip-compressed on disk
+	snapFile, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	magic := make([]byte, 2)
+	if _, err := snapFile.Read(magic); err != nil {
+		t.Fatal(err)
+	}
+	if magic[0] != gzipMagic[0] || magic[1] != gzipMagic[1] {
+		t.Fatalf("expected the flushed snapshot to start with the gzip magic, got %v", magic)
+	}
+	if err := snapFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening, even with CodecPlain requested, must still read back the gzip snapshot: the
+	// codec used for reading is autodetected, not taken from Options
+	reopened, err := OpenTableWithOptions(path, TestRecordType{}, Options{Storage: storage, Codec: CodecPlain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	records, err := reopened.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records after reopening a gzip-compressed snapshot, got %v", records)
+	}
+}
+
+// TestSnappyCodec mirrors TestPluggableStorageAndCodec for CodecSnappy: the flushed snapshot must
+// actually be snappy-framed on disk, and autodetection must read it back regardless of the codec
+// requested on reopen.
+func TestSnappyCodec(t *testing.T) {
+	storage := NewMemStorage()
+	opts := Options{Storage: storage, Codec: CodecSnappy}
+	path := "./test_storage_snappy.csv"
+
+	table, err := OpenTableWithOptions(path, TestRecordType{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if err := table.InsertAll([]RecordType{
+		TestRecordType{Id: 1, Name: "abc"},
+		TestRecordType{Id: 2, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// flush synchronously instead of going through the ListenChange/changed-channel plumbing
+	if err := table.flushSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot file must actually be snappy-framed on disk
+	snapFile, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	magic := make([]byte, len(snappyMagic))
+	if _, err := snapFile.Read(magic); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(magic, snappyMagic) {
+		t.Fatalf("expected the flushed snapshot to start with the snappy magic, got %v", magic)
+	}
+	if err := snapFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopening, even with CodecPlain requested, must still read back the snappy snapshot: the
+	// codec used for reading is autodetected, not taken from Options
+	reopened, err := OpenTableWithOptions(path, TestRecordType{}, Options{Storage: storage, Codec: CodecPlain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	records, err := reopened.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records after reopening a snappy-compressed snapshot, got %v", records)
+	}
+}
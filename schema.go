@@ -0,0 +1,194 @@
+package scd
+
+// SchemaOptions configures OpenTableWithHeader.
+type SchemaOptions struct {
+	// Indexes names the header columns to maintain as in-memory indexes, making lookups on
+	// those columns O(1)+match instead of a linear scan. Equivalent to calling EnsureIndex for
+	// each name right after opening the table.
+	Indexes []string
+}
+
+// OpenTableWithHeader opens a table like OpenTable, but treats the first line of the csv file as
+// a header row naming its columns instead of data. The header enables SelectByName, SelectAllByName,
+// UpdateByName, UpdateAllByName, DeleteByName and DeleteAllByName, and opts.Indexes names the
+// columns to maintain as in-memory indexes from the start.
+func OpenTableWithHeader(path string, recordType RecordType, opts SchemaOptions) (*Table, error) {
+	t, err := openTable(path, recordType, Options{}, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, colName := range opts.Indexes {
+		if err := t.EnsureIndex(colName); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// colByName resolves a header column name to its column index. t.colIndex is nil for tables
+// opened without a header, so this always reports not-found for them.
+func (t *Table) colByName(name string) (int, bool) {
+	col, ok := t.colIndex[name]
+	return col, ok
+}
+
+// ColumnIndex resolves a header column name to its column index, for tables opened with
+// OpenTableWithHeader. It reports false if the table has no header or the name is unknown.
+func (t *Table) ColumnIndex(name string) (int, bool) {
+	return t.colByName(name)
+}
+
+// ColumnNames returns a copy of the table's header, and whether the table has one at all;
+// tables opened with OpenTable or OpenTableWithOptions never do.
+func (t *Table) ColumnNames() ([]string, bool) {
+	if t.header == nil {
+		return nil, false
+	}
+	names := make([]string, len(t.header))
+	copy(names, t.header)
+	return names, true
+}
+
+// EnsureIndex builds an in-memory index for colName from the table's current rows if one does
+// not already exist. Subsequent Select/SelectAll/Delete/DeleteAll calls on that column, by index
+// or by name, use the index instead of scanning every row; Update and UpdateAll still scan every
+// row, and only keep the index in sync.
+func (t *Table) EnsureIndex(colName string) error {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return FindOutOfIndex
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.indexes[colName]; ok {
+		return nil
+	}
+	bucket := make(map[string][]int)
+	for i, row := range t.rows {
+		if col >= len(row) {
+			continue
+		}
+		bucket[row[col]] = append(bucket[row[col]], i)
+	}
+	if t.indexes == nil {
+		t.indexes = make(map[string]map[string][]int)
+	}
+	t.indexes[colName] = bucket
+	return nil
+}
+
+// indexedBucket returns the index bucket for col, if col has a name (the table has a header)
+// and that name is indexed. t.mutex must already be held.
+func (t *Table) indexedBucket(col int) (map[string][]int, bool) {
+	name, ok := t.colNames[col]
+	if !ok {
+		return nil, false
+	}
+	bucket, ok := t.indexes[name]
+	return bucket, ok
+}
+
+// indexInsert records row as living at idx in every maintained index. t.mutex must be held.
+func (t *Table) indexInsert(idx int, row []string) {
+	for colName, bucket := range t.indexes {
+		col := t.colIndex[colName]
+		if col >= len(row) {
+			continue
+		}
+		v := row[col]
+		bucket[v] = append(bucket[v], idx)
+	}
+}
+
+// indexRemove removes idx from every maintained index's bucket for row's value. t.mutex must be
+// held.
+func (t *Table) indexRemove(idx int, row []string) {
+	for colName, bucket := range t.indexes {
+		col := t.colIndex[colName]
+		if col >= len(row) {
+			continue
+		}
+		v := row[col]
+		ids := bucket[v]
+		for i, rowIdx := range ids {
+			if rowIdx == idx {
+				bucket[v] = append(ids[:i], ids[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// indexMove updates every maintained index to reflect that the row at oldIdx now lives at
+// newIdx, used after a swap-delete moves the last row into the freed slot. t.mutex must be held.
+func (t *Table) indexMove(oldIdx, newIdx int, row []string) {
+	for colName, bucket := range t.indexes {
+		col := t.colIndex[colName]
+		if col >= len(row) {
+			continue
+		}
+		ids := bucket[row[col]]
+		for i, rowIdx := range ids {
+			if rowIdx == oldIdx {
+				ids[i] = newIdx
+				break
+			}
+		}
+	}
+}
+
+// SelectByName looks up a row by column name instead of column index, as Select does.
+func (t *Table) SelectByName(colName, value string) (RecordType, error) {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return nil, FindOutOfIndex
+	}
+	return t.Select(col, value)
+}
+
+// SelectAllByName rows that has the specified value on the named column, as SelectAll does.
+func (t *Table) SelectAllByName(colName, value string) ([]RecordType, error) {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return nil, FindOutOfIndex
+	}
+	return t.SelectAll(col, value)
+}
+
+// UpdateByName updates a row by column name instead of column index, as Update does.
+func (t *Table) UpdateByName(colName, id string, record RecordType) error {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return FindOutOfIndex
+	}
+	return t.Update(col, id, record)
+}
+
+// UpdateAllByName updates every row that has the specified value on the named column, as
+// UpdateAll does.
+func (t *Table) UpdateAllByName(colName, by string, record RecordType) error {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return FindOutOfIndex
+	}
+	return t.UpdateAll(col, by, record)
+}
+
+// DeleteByName deletes a row by column name instead of column index, as Delete does.
+func (t *Table) DeleteByName(colName, id string) error {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return FindOutOfIndex
+	}
+	return t.Delete(col, id)
+}
+
+// DeleteAllByName deletes every row that has the specified value on the named column, as
+// DeleteAll does.
+func (t *Table) DeleteAllByName(colName, by string) error {
+	col, ok := t.colByName(colName)
+	if !ok {
+		return FindOutOfIndex
+	}
+	return t.DeleteAll(col, by)
+}
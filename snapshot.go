@@ -0,0 +1,114 @@
+package scd
+
+// Snapshot is a point-in-time, read-only view of a Table's rows. Taking one never blocks
+// writers and never copies the rows up front: it shares the table's current backing array, and
+// the table clones that array on its next mutation (see Table.cloneIfShared) so the snapshot's
+// view never changes underneath a reader.
+type Snapshot struct {
+	rows       [][]string
+	recordType RecordType
+	colIndex   map[string]int
+}
+
+// Snapshot captures the table's current rows without holding the lock for the scan that follows.
+func (t *Table) Snapshot() *Snapshot {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.sharedWithSnapshot = true
+	return &Snapshot{
+		rows:       t.rows,
+		recordType: t.recordType,
+		colIndex:   t.colIndex,
+	}
+}
+
+// Iter streams records out of a Snapshot in row order.
+type Iter struct {
+	rows       [][]string
+	recordType RecordType
+	idx        int
+	cur        RecordType
+	err        error
+}
+
+// Iterator returns an Iter over every row in the snapshot.
+func (s *Snapshot) Iterator() *Iter {
+	return &Iter{rows: s.rows, recordType: s.recordType}
+}
+
+// Next advances the iterator and reports whether a record is available. It stops, returning
+// false, once every row has been visited or a row fails to convert; check Err to tell those
+// cases apart.
+func (it *Iter) Next() bool {
+	if it.err != nil || it.idx >= len(it.rows) {
+		return false
+	}
+	record, err := it.recordType.FromRow(it.rows[it.idx])
+	it.idx++
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.cur = record
+	return true
+}
+
+// Record returns the record produced by the most recent call to Next.
+func (it *Iter) Record() (RecordType, error) {
+	return it.cur, it.err
+}
+
+// Err returns the first error encountered while iterating, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// Release drops the iterator's reference to the snapshot's rows.
+func (it *Iter) Release() {
+	it.rows = nil
+	it.cur = nil
+}
+
+// Where streams every record in the snapshot for which pred returns true, in row order, without
+// taking the table's lock.
+func (s *Snapshot) Where(pred func(RecordType) bool) ([]RecordType, error) {
+	results := make([]RecordType, 0)
+	it := s.Iterator()
+	defer it.Release()
+	for it.Next() {
+		record, err := it.Record()
+		if err != nil {
+			return nil, err
+		}
+		if pred(record) {
+			results = append(results, record)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Find returns every row in the snapshot whose named column equals value, without taking the
+// table's lock. The table must have been opened with OpenTableWithHeader.
+func (s *Snapshot) Find(colName, value string) ([]RecordType, error) {
+	col, ok := s.colIndex[colName]
+	if !ok {
+		return nil, FindOutOfIndex
+	}
+	records := make([]RecordType, 0)
+	for _, row := range s.rows {
+		if col >= len(row) {
+			return nil, FindOutOfIndex
+		}
+		if row[col] == value {
+			record, err := s.recordType.FromRow(row)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
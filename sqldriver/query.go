@@ -0,0 +1,78 @@
+package sqldriver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// query is the result of parsing a `SELECT <cols|*> FROM t [WHERE col = ?] [LIMIT n]` statement.
+// The table name after FROM is not tracked: a conn is already bound to a single *scd.Table, so
+// it is accepted but ignored.
+type query struct {
+	cols     []string // empty means "*"
+	where    string   // column reference on the left of the WHERE clause, "" if there is none
+	limit    int
+	hasLimit bool
+}
+
+// parseQuery implements the tiny SELECT dialect this driver supports. It is deliberately
+// minimal: one optional WHERE equality against a single "?" placeholder, and one optional LIMIT.
+func parseQuery(q string) (*query, error) {
+	upper := strings.ToUpper(q)
+	if !strings.HasPrefix(strings.TrimSpace(upper), "SELECT") {
+		return nil, fmt.Errorf("sqldriver: only SELECT statements are supported, got %q", q)
+	}
+	fromIdx := strings.Index(upper, "FROM")
+	if fromIdx < 0 {
+		return nil, fmt.Errorf("sqldriver: missing FROM in %q", q)
+	}
+	colsPart := strings.TrimSpace(q[strings.Index(upper, "SELECT")+len("SELECT") : fromIdx])
+	rest := strings.TrimSpace(q[fromIdx+len("FROM"):])
+
+	restUpper := strings.ToUpper(rest)
+	whereIdx := strings.Index(restUpper, "WHERE")
+	limitIdx := strings.Index(restUpper, "LIMIT")
+
+	var wherePart, limitPart string
+	switch {
+	case whereIdx >= 0 && limitIdx > whereIdx:
+		wherePart = strings.TrimSpace(rest[whereIdx+len("WHERE") : limitIdx])
+		limitPart = strings.TrimSpace(rest[limitIdx+len("LIMIT"):])
+	case whereIdx >= 0:
+		wherePart = strings.TrimSpace(rest[whereIdx+len("WHERE"):])
+	case limitIdx >= 0:
+		limitPart = strings.TrimSpace(rest[limitIdx+len("LIMIT"):])
+	}
+
+	parsed := &query{}
+
+	if colsPart != "*" && colsPart != "" {
+		for _, c := range strings.Split(colsPart, ",") {
+			parsed.cols = append(parsed.cols, strings.TrimSpace(c))
+		}
+	}
+
+	if wherePart != "" {
+		eqIdx := strings.Index(wherePart, "=")
+		if eqIdx < 0 {
+			return nil, fmt.Errorf("sqldriver: unsupported WHERE clause %q, want \"col = ?\"", wherePart)
+		}
+		placeholder := strings.TrimSpace(wherePart[eqIdx+1:])
+		if placeholder != "?" {
+			return nil, fmt.Errorf("sqldriver: WHERE value must be a single ? placeholder, got %q", placeholder)
+		}
+		parsed.where = strings.TrimSpace(wherePart[:eqIdx])
+	}
+
+	if limitPart != "" {
+		n, err := strconv.Atoi(limitPart)
+		if err != nil {
+			return nil, fmt.Errorf("sqldriver: invalid LIMIT %q: %w", limitPart, err)
+		}
+		parsed.limit = n
+		parsed.hasLimit = true
+	}
+
+	return parsed, nil
+}
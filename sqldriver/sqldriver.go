@@ -0,0 +1,221 @@
+// Package sqldriver adapts an scd.Table into a read-only database/sql/driver.Driver, so a CSV
+// table can stand in as a database/sql data source (for example a fixture fed to go-sqlmock, or
+// code already written against database/sql).
+package sqldriver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	scd "github.com/zenpk/safe-csv-db"
+)
+
+func init() {
+	sql.Register("scd", &Driver{})
+}
+
+var (
+	mu     sync.Mutex
+	tables = make(map[string]*scd.Table)
+)
+
+// Register makes table queryable as sql.Open("scd", name). Registration is required because
+// database/sql connects by name, not by Go value.
+func Register(name string, table *scd.Table) {
+	mu.Lock()
+	defer mu.Unlock()
+	tables[name] = table
+}
+
+// Driver implements database/sql/driver.Driver over tables registered with Register.
+type Driver struct{}
+
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	mu.Lock()
+	table, ok := tables[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("sqldriver: no table registered under %q", name)
+	}
+	return &conn{table: table}, nil
+}
+
+type conn struct {
+	table *scd.Table
+}
+
+func (c *conn) Prepare(q string) (driver.Stmt, error) {
+	parsed, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{table: c.table, query: parsed}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return nil, errors.New("sqldriver: table is read-only, transactions are not supported")
+}
+
+type stmt struct {
+	table *scd.Table
+	query *query
+}
+
+func (s *stmt) Close() error { return nil }
+
+func (s *stmt) NumInput() int {
+	if s.query.where == "" {
+		return 0
+	}
+	return 1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("sqldriver: table is read-only")
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	whereCol := -1
+	if s.query.where != "" {
+		col, err := resolveCol(s.table, s.query.where)
+		if err != nil {
+			return nil, err
+		}
+		whereCol = col
+	}
+
+	snap := s.table.Snapshot()
+	it := snap.Iterator()
+	defer it.Release()
+
+	matched := make([][]string, 0)
+	for it.Next() {
+		record, err := it.Record()
+		if err != nil {
+			return nil, err
+		}
+		row, err := record.ToRow()
+		if err != nil {
+			return nil, err
+		}
+		if whereCol >= 0 && (whereCol >= len(row) || row[whereCol] != valueToString(args[0])) {
+			continue
+		}
+		matched = append(matched, row)
+		if s.query.hasLimit && len(matched) >= s.query.limit {
+			break
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	sampleWidth := 0
+	if len(matched) > 0 {
+		sampleWidth = len(matched[0])
+	}
+	cols, colIdx, err := resolveProjection(s.table, s.query.cols, sampleWidth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{cols: cols, colIdx: colIdx, data: matched}, nil
+}
+
+// resolveCol resolves a column reference to an index: a header name via the table's schema, or
+// a positional "$0", "$1", ... reference when the table has no header.
+func resolveCol(table *scd.Table, name string) (int, error) {
+	if strings.HasPrefix(name, "$") {
+		n, err := strconv.Atoi(name[1:])
+		if err != nil {
+			return 0, fmt.Errorf("sqldriver: invalid positional column %q", name)
+		}
+		return n, nil
+	}
+	col, ok := table.ColumnIndex(name)
+	if !ok {
+		return 0, fmt.Errorf("sqldriver: unknown column %q", name)
+	}
+	return col, nil
+}
+
+// resolveProjection resolves the SELECT column list (or "*") to the result column names and
+// their indices into each matched row. sampleWidth is used to name positional columns for "*"
+// when the table has no header.
+func resolveProjection(table *scd.Table, requested []string, sampleWidth int) ([]string, []int, error) {
+	if len(requested) == 0 {
+		if names, ok := table.ColumnNames(); ok {
+			idx := make([]int, len(names))
+			for i := range idx {
+				idx[i] = i
+			}
+			return names, idx, nil
+		}
+		names := make([]string, sampleWidth)
+		idx := make([]int, sampleWidth)
+		for i := 0; i < sampleWidth; i++ {
+			names[i] = fmt.Sprintf("$%d", i)
+			idx[i] = i
+		}
+		return names, idx, nil
+	}
+
+	names := make([]string, len(requested))
+	idx := make([]int, len(requested))
+	for i, name := range requested {
+		col, err := resolveCol(table, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		names[i] = name
+		idx[i] = col
+	}
+	return names, idx, nil
+}
+
+func valueToString(v driver.Value) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(value)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// rows streams a Snapshot-sourced result set as driver.Rows.
+type rows struct {
+	cols   []string
+	colIdx []int
+	data   [][]string
+	pos    int
+}
+
+func (r *rows) Columns() []string { return r.cols }
+
+func (r *rows) Close() error { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		return io.EOF
+	}
+	row := r.data[r.pos]
+	r.pos++
+	for i, col := range r.colIdx {
+		if col < len(row) {
+			dest[i] = row[col]
+		} else {
+			dest[i] = nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package sqldriver
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+
+	scd "github.com/zenpk/safe-csv-db"
+)
+
+type testRecord struct {
+	Id   int64
+	Name string
+}
+
+func (r testRecord) ToRow() ([]string, error) {
+	return []string{strconv.FormatInt(r.Id, 10), r.Name}, nil
+}
+
+func (r testRecord) FromRow(row []string) (scd.RecordType, error) {
+	if len(row) < 2 {
+		return nil, errors.New("out of range")
+	}
+	id, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return testRecord{Id: id, Name: row[1]}, nil
+}
+
+// TestQueryByHeaderName checks a WHERE clause resolved against a header-based schema, including
+// LIMIT and an explicit column list.
+func TestQueryByHeaderName(t *testing.T) {
+	path := "./test_header.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	if err := os.WriteFile(path, []byte("id,name\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := scd.OpenTableWithHeader(path, testRecord{}, scd.SchemaOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if err := table.InsertAll([]scd.RecordType{
+		testRecord{Id: 1, Name: "abc"},
+		testRecord{Id: 2, Name: "def"},
+		testRecord{Id: 3, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	Register("header", table)
+	db, err := sql.Open("scd", "header")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, name FROM t WHERE name = ? LIMIT 1", "def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "def" {
+			t.Fatalf("expected only rows named def, got %q", name)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected LIMIT 1 to cap the result at 1 row, got %d", count)
+	}
+}
+
+// TestQueryByPositionalColumn checks that "$0"-style positional references work against a table
+// opened without a header.
+func TestQueryByPositionalColumn(t *testing.T) {
+	path := "./test_positional.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := scd.OpenTable(path, testRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	if err := table.InsertAll([]scd.RecordType{
+		testRecord{Id: 1, Name: "abc"},
+		testRecord{Id: 2, Name: "def"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	Register("positional", table)
+	db, err := sql.Open("scd", "positional")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT * FROM t WHERE $0 = ?", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range dest {
+			ptrs[i] = &dest[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatal(err)
+		}
+		if dest[0].(string) != "2" {
+			t.Fatalf("expected $0 = 2 to select the row with id 2, got %v", dest)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly 1 matching row, got %d", count)
+	}
+}
+
+// TestQueryUnsupportedSyntax checks that statements outside the tiny supported dialect are
+// rejected with an error instead of being misparsed.
+func TestQueryUnsupportedSyntax(t *testing.T) {
+	path := "./test_unsupported.csv"
+	defer os.Remove(path)
+	defer os.Remove(path + ".wal")
+
+	table, err := scd.OpenTable(path, testRecord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	Register("unsupported", table)
+	db, err := sql.Open("scd", "unsupported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Query("DELETE FROM t"); err == nil {
+		t.Fatal("expected an error for a non-SELECT statement")
+	}
+	if _, err := db.Query("SELECT * FROM t WHERE name > ?", "abc"); err == nil {
+		t.Fatal("expected an error for a non-equality WHERE clause")
+	}
+	if _, err := db.Query("SELECT * FROM t WHERE name = 'abc'"); err == nil {
+		t.Fatal("expected an error for a WHERE value that isn't a single ? placeholder")
+	}
+}
@@ -0,0 +1,214 @@
+package scd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// File is the subset of *os.File that a Storage implementation must hand back; it is enough for
+// both the table's csv snapshot and its write-ahead log.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// Storage abstracts the filesystem calls Table makes, so a table can be backed by something
+// other than the local disk. FileStorage is the default and behaves exactly as earlier versions
+// of this package always did; MemStorage is provided for tests that want a Table without
+// touching disk at all.
+type Storage interface {
+	// Open opens name for reading and writing, creating it if it does not already exist.
+	Open(name string) (File, error)
+	// Create opens name for reading and writing, truncating it if it already exists.
+	Create(name string) (File, error)
+	// Exists reports whether name exists.
+	Exists(name string) (bool, error)
+	// Rename atomically replaces newName's contents with oldName's, as os.Rename does.
+	Rename(oldName, newName string) error
+	// Remove deletes name. It is not an error if name does not exist.
+	Remove(name string) error
+}
+
+// fileSize returns f's current length, leaving f's position at the start. Used in place of
+// os.FileInfo.Size so size-checking works through the Storage/File abstraction generically.
+func fileSize(f File) (int64, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// FileStorage is the default Storage, backed by the local filesystem.
+type FileStorage struct{}
+
+func (FileStorage) Open(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+func (FileStorage) Create(name string) (File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (FileStorage) Exists(name string) (bool, error) {
+	if _, err := os.Stat(name); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (FileStorage) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (FileStorage) Remove(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// MemStorage is an in-memory Storage: every name lives in a map instead of on disk. It is meant
+// for tests that want a Table without leaving files behind.
+type MemStorage struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+// NewMemStorage returns an empty MemStorage.
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string]*memFile)}
+}
+
+func (s *MemStorage) Open(name string) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[name]
+	if !ok {
+		f = &memFile{}
+		s.files[name] = f
+	}
+	return f.handle(), nil
+}
+
+func (s *MemStorage) Create(name string) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := &memFile{}
+	s.files[name] = f
+	return f.handle(), nil
+}
+
+func (s *MemStorage) Exists(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.files[name]
+	return ok, nil
+}
+
+func (s *MemStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	s.files[newName] = f
+	delete(s.files, oldName)
+	return nil
+}
+
+func (s *MemStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, name)
+	return nil
+}
+
+// memFile is the backing store for one name in a MemStorage. handle hands out a fresh read/write
+// position over the same bytes each time, mirroring how repeated os.OpenFile calls on one path
+// share the underlying file but not the resulting *os.File's offset.
+type memFile struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *memFile) handle() *memHandle {
+	return &memHandle{file: f}
+}
+
+type memHandle struct {
+	file *memFile
+	pos  int64
+}
+
+func (h *memHandle) Read(p []byte) (int, error) {
+	h.file.mu.Lock()
+	defer h.file.mu.Unlock()
+	if h.pos >= int64(len(h.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, h.file.data[h.pos:])
+	h.pos += int64(n)
+	return n, nil
+}
+
+func (h *memHandle) Write(p []byte) (int, error) {
+	h.file.mu.Lock()
+	defer h.file.mu.Unlock()
+	end := h.pos + int64(len(p))
+	if end > int64(len(h.file.data)) {
+		grown := make([]byte, end)
+		copy(grown, h.file.data)
+		h.file.data = grown
+	}
+	n := copy(h.file.data[h.pos:end], p)
+	h.pos += int64(n)
+	return n, nil
+}
+
+func (h *memHandle) Seek(offset int64, whence int) (int64, error) {
+	h.file.mu.Lock()
+	size := int64(len(h.file.data))
+	h.file.mu.Unlock()
+	switch whence {
+	case io.SeekStart:
+		h.pos = offset
+	case io.SeekCurrent:
+		h.pos += offset
+	case io.SeekEnd:
+		h.pos = size + offset
+	default:
+		return 0, errors.New("scd: invalid whence")
+	}
+	return h.pos, nil
+}
+
+func (h *memHandle) Close() error { return nil }
+
+func (h *memHandle) Sync() error { return nil }
+
+func (h *memHandle) Truncate(size int64) error {
+	h.file.mu.Lock()
+	defer h.file.mu.Unlock()
+	if size <= int64(len(h.file.data)) {
+		h.file.data = h.file.data[:size]
+		return nil
+	}
+	grown := make([]byte, size)
+	copy(grown, h.file.data)
+	h.file.data = grown
+	return nil
+}
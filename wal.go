@@ -0,0 +1,268 @@
+package scd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// walOp identifies which Table method a WAL record reproduces during replay.
+type walOp byte
+
+const (
+	walInsert walOp = iota
+	walInsertAll
+	walUpdate
+	walUpdateAll
+	walDelete
+	walDeleteAll
+)
+
+// SyncMode controls how aggressively the write-ahead log is flushed to disk.
+type SyncMode int
+
+const (
+	// SyncNone does not fsync the WAL after a mutating operation (default). On a crash,
+	// operations written since the last fsync'd record may be lost, but the table itself
+	// is never left corrupted.
+	SyncNone SyncMode = iota
+	// SyncAlways fsyncs the WAL after every mutating operation, before the call returns.
+	SyncAlways
+)
+
+// appendWAL writes a single length-prefixed, checksummed record to the table's WAL file.
+// t.mutex must be held by the caller.
+func (t *Table) appendWAL(op walOp, payload []byte) error {
+	record := make([]byte, 0, 1+len(payload))
+	record = append(record, byte(op))
+	record = append(record, payload...)
+
+	var lenBuf, crcBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(record)))
+	binary.BigEndian.PutUint32(crcBuf[:], crc32.ChecksumIEEE(record))
+
+	if _, err := t.walFile.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	if _, err := t.walFile.Write(crcBuf[:]); err != nil {
+		return err
+	}
+	if _, err := t.walFile.Write(record); err != nil {
+		return err
+	}
+	if t.syncMode == SyncAlways {
+		return t.walFile.Sync()
+	}
+	return nil
+}
+
+// replayWAL reads every record from the start of walFile, verifies its checksum, and applies
+// it to rows in order. It returns the resulting rows, or an *ErrCorrupted describing the first
+// record that fails verification so the caller can decide where to truncate.
+func replayWAL(walFile io.ReadSeeker, rows [][]string) ([][]string, error) {
+	if _, err := walFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	var offset int64
+	for {
+		var lenBuf, crcBuf [4]byte
+		if _, err := io.ReadFull(walFile, lenBuf[:]); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, &ErrCorrupted{Reason: "truncated record length", Offset: offset}
+		}
+		if _, err := io.ReadFull(walFile, crcBuf[:]); err != nil {
+			return nil, &ErrCorrupted{Reason: "truncated record checksum", Offset: offset}
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		record := make([]byte, n)
+		if _, err := io.ReadFull(walFile, record); err != nil {
+			return nil, &ErrCorrupted{Reason: "truncated record payload", Offset: offset}
+		}
+		if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(crcBuf[:]) {
+			return nil, &ErrCorrupted{Reason: "checksum mismatch", Offset: offset}
+		}
+		var err error
+		rows, err = applyWALRecord(rows, record)
+		if err != nil {
+			return nil, &ErrCorrupted{Reason: err.Error(), Offset: offset}
+		}
+		offset += 8 + int64(n)
+	}
+	return rows, nil
+}
+
+// applyWALRecord decodes a single record and applies it to rows the same way the corresponding
+// Table method would have.
+func applyWALRecord(rows [][]string, record []byte) ([][]string, error) {
+	if len(record) < 1 {
+		return nil, errors.New("empty WAL record")
+	}
+	r := bytes.NewReader(record[1:])
+	switch walOp(record[0]) {
+	case walInsert:
+		row, err := decodeRow(r)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	case walInsertAll:
+		newRows, err := decodeRows(r)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, newRows...)
+	case walUpdate:
+		col, id, err := decodeColID(r)
+		if err != nil {
+			return nil, err
+		}
+		row, err := decodeRow(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if col < len(rows[i]) && rows[i][col] == id {
+				rows[i] = row
+				break
+			}
+		}
+	case walUpdateAll:
+		col, by, err := decodeColID(r)
+		if err != nil {
+			return nil, err
+		}
+		row, err := decodeRow(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if col < len(rows[i]) && rows[i][col] == by {
+				rows[i] = row
+			}
+		}
+	case walDelete:
+		col, id, err := decodeColID(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			if col < len(rows[i]) && rows[i][col] == id {
+				rows[i] = rows[len(rows)-1]
+				rows = rows[:len(rows)-1]
+				break
+			}
+		}
+	case walDeleteAll:
+		col, by, err := decodeColID(r)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(rows) - 1; i >= 0; i-- {
+			if col < len(rows[i]) && rows[i][col] == by {
+				rows[i] = rows[len(rows)-1]
+				rows = rows[:len(rows)-1]
+			}
+		}
+	default:
+		return nil, errors.New("unknown WAL op")
+	}
+	return rows, nil
+}
+
+func encodeRow(row []string) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(len(row)))
+	for _, s := range row {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(s)))
+		buf = append(buf, l[:]...)
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+func encodeRows(rows [][]string) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(len(rows)))
+	for _, row := range rows {
+		buf = append(buf, encodeRow(row)...)
+	}
+	return buf
+}
+
+func encodeColID(col int, id string) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(col))
+	var l [4]byte
+	binary.BigEndian.PutUint32(l[:], uint32(len(id)))
+	buf = append(buf, l[:]...)
+	buf = append(buf, id...)
+	return buf
+}
+
+func encodeColIDRow(col int, id string, row []string) []byte {
+	buf := encodeColID(col, id)
+	buf = append(buf, encodeRow(row)...)
+	return buf
+}
+
+func decodeString(r *bytes.Reader) (string, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return "", err
+	}
+	buf := make([]byte, binary.BigEndian.Uint32(l[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func decodeRow(r *bytes.Reader) ([]string, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	row := make([]string, binary.BigEndian.Uint32(l[:]))
+	for i := range row {
+		s, err := decodeString(r)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = s
+	}
+	return row, nil
+}
+
+func decodeRows(r *bytes.Reader) ([][]string, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	rows := make([][]string, binary.BigEndian.Uint32(l[:]))
+	for i := range rows {
+		row, err := decodeRow(r)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+	return rows, nil
+}
+
+func decodeColID(r *bytes.Reader) (int, string, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return 0, "", err
+	}
+	col := int(binary.BigEndian.Uint32(l[:]))
+	id, err := decodeString(r)
+	if err != nil {
+		return 0, "", err
+	}
+	return col, id, nil
+}